@@ -4,6 +4,7 @@ package benwh
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,6 +13,8 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -151,61 +154,293 @@ type Config struct {
 	Password string
 }
 
+// State identifies where a Conn sits in its connection lifecycle.
+type State int
+
+const (
+	// Unauthenticated is the state of a Conn before a login token
+	// has been obtained.
+	Unauthenticated State = iota
+	// Authenticated is the normal operating state: the Conn holds
+	// a token the server is honoring.
+	Authenticated
+	// Throttled means the server answered with the "retry later"
+	// code (102); the caller should back off before trying again.
+	Throttled
+	// Expired means the server rejected the current token; a
+	// fresh appUserOrInstallerLogin is required before further
+	// requests will succeed.
+	Expired
+	// Broken means the server returned something login can't
+	// recover from (e.g. bad credentials); the Conn should be
+	// discarded.
+	Broken
+)
+
+// String renders a State for logging.
+func (s State) String() string {
+	switch s {
+	case Unauthenticated:
+		return "unauthenticated"
+	case Authenticated:
+		return "authenticated"
+	case Throttled:
+		return "throttled"
+	case Expired:
+		return "expired"
+	case Broken:
+		return "broken"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// Transition describes a single State change of a Conn.
+type Transition struct {
+	From, To State
+}
+
 // Conn holds an open connection to the service.
 type Conn struct {
 	config Config
-	token  string
 	client *http.Client
+
+	mu    sync.Mutex
+	state State
+	token string
+	snno  int
+
+	onTransition func(Transition)
+	limiter      atomic.Pointer[rateLimiter]
+
+	// store, if set, is consulted by login for a fresh Config each
+	// time it (re-)authenticates, so a rotated password is picked up
+	// without the caller having to build a new Conn.
+	store CredentialStore
+}
+
+// OnTransition registers f to be called, synchronously, every time
+// the Conn's State changes. It is intended for logging or alerting;
+// pass nil to stop receiving transitions. Use a buffered channel send
+// inside f if the Conn must not be blocked by a slow consumer.
+func (conn *Conn) OnTransition(f func(Transition)) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.onTransition = f
+}
+
+// State returns the Conn's current State.
+func (conn *Conn) State() State {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.state
+}
+
+// transition moves the Conn to the given State and, if a callback is
+// registered, reports the Transition once the lock is released.
+func (conn *Conn) transition(to State) {
+	conn.mu.Lock()
+	from := conn.state
+	conn.state = to
+	f := conn.onTransition
+	conn.mu.Unlock()
+	if f != nil && from != to {
+		f(Transition{From: from, To: to})
+	}
 }
 
 // NewConn creates an authenticated connection to ther FranklinWH
 // service.
 func NewConn(conf Config) (conn *Conn, err error) {
-	c := &http.Client{}
+	return newConn(conf, nil)
+}
+
+// newConn builds and authenticates a Conn for conf, consulting store
+// (which may be nil) for a fresh Config on every subsequent login.
+func newConn(conf Config, store CredentialStore) (conn *Conn, err error) {
+	conn = &Conn{
+		config: conf,
+		client: &http.Client{},
+		state:  Unauthenticated,
+		store:  store,
+	}
+	conn.limiter.Store(newRateLimiter(1, 2))
+	if err = conn.login(); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// nextSnno returns the next value to use for a request's Snno field.
+// Concurrent polling of multiple devices on the same account can have
+// several requests in flight at once, and the server appears to
+// expect a distinct, increasing sequence number per account rather
+// than a constant.
+func (conn *Conn) nextSnno() int {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.snno++
+	return conn.snno
+}
+
+// login performs the appUserOrInstallerLogin exchange and installs
+// the resulting token, transitioning the Conn to Authenticated on
+// success or Broken if the credentials are rejected outright.
+func (conn *Conn) login() error {
+	conn.mu.Lock()
+	store := conn.store
+	conf := conn.config
+	conn.mu.Unlock()
+
+	if store != nil {
+		fresh, err := store.Load()
+		if err != nil {
+			return fmt.Errorf("credential store reload failed: %v", err)
+		}
+		conf = fresh
+		conn.mu.Lock()
+		conn.config = fresh
+		conn.mu.Unlock()
+	}
+
 	v := url.Values{}
 	v.Set("account", conf.Email)
 	v.Set("password", conf.Password)
 	v.Set("lang", "EN_US")
 	v.Set("type", "1")
 	v.Set("user-agent", userAgent)
-	res, err := c.PostForm(URLBase+"hes-gateway/terminal/initialize/appUserOrInstallerLogin", v)
+	res, err := conn.client.PostForm(URLBase+"hes-gateway/terminal/initialize/appUserOrInstallerLogin", v)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	d, err := io.ReadAll(res.Body)
+	res.Body.Close()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	res.Body.Close()
 	var resp LoginResponse
 	if err := json.Unmarshal(d, &resp); err != nil {
-		return nil, err
+		return err
 	}
-	conn = &Conn{
-		config: conf,
-		token:  resp.Result.Token,
-		client: c,
+	if !resp.Success || resp.Result.Token == "" {
+		conn.transition(Broken)
+		return fmt.Errorf("login failed: %s (code %d)", resp.Message, resp.Code)
+	}
+	conn.mu.Lock()
+	conn.token = resp.Result.Token
+	conn.mu.Unlock()
+	conn.transition(Authenticated)
+	return nil
+}
+
+// Ensure makes sure the Conn is in a state where a request is likely
+// to succeed, re-authenticating if the token has expired. Callers
+// that want fine-grained control over when re-authentication happens
+// can call this before Status; Status also calls it transparently.
+func (conn *Conn) Ensure(ctx context.Context) error {
+	switch conn.State() {
+	case Authenticated, Throttled:
+		// Throttled is transient: the server said "retry later" on
+		// a previous call, but that doesn't mean the token is bad.
+		// Let the next call go through; doCall will report
+		// ErrRetryLater again (and re-set Throttled) if the server
+		// is still refusing us.
+		return nil
+	case Unauthenticated, Expired:
+		return conn.login()
+	default:
+		return fmt.Errorf("connection is broken, a new Conn is required")
 	}
-	return
 }
 
+// errAuthExpired is returned internally by doCall when the server
+// indicates the current token is no longer valid, so call knows to
+// re-authenticate and retry exactly once.
+var errAuthExpired = errors.New("auth token expired")
+
 // ErrRetryLater is used to indicate a timeout occurred and retrying after
 // some wait time is likely to work.
 var ErrRetryLater = errors.New("retry later")
 
-// Status returns the system status.
+// Response codes observed from sendMqtt. These are reverse engineered
+// from the FranklinWH app and are not documented by the vendor.
+const (
+	mqttCodeOK = 200
+	// mqttCodeRetryLater seems to require a simple retry.
+	mqttCodeRetryLater = 102
+	// mqttCodeTokenExpired and mqttCodeTokenInvalid are presumed to
+	// indicate the login token needs to be refreshed; they have not
+	// been exhaustively confirmed against the real service.
+	mqttCodeTokenExpired = 401
+	mqttCodeTokenInvalid = 403
+)
+
+// cmdTypeStatus is the MQTTRequest.CmdType for a read-only status
+// request; see commands.go for the cmdType values used by the
+// control operations layered on top of call.
+const cmdTypeStatus = 203
+
+// Status returns the system status of the first configured device.
+// Use StatusFor or StatusAll to address other devices in
+// conn.config.Device.
 func (conn *Conn) Status() (resp *DataStatus, err error) {
-	req := []byte(`{"opt":1,"refreshData":1}`)
-	checksum := crc32.ChecksumIEEE(req)
+	return conn.StatusFor(context.Background(), conn.config.Device[0])
+}
+
+// StatusFor returns the system status of the device identified by
+// equipNo, transparently re-authenticating once if the server reports
+// the token has expired, and obeying the Conn's rate limiter.
+func (conn *Conn) StatusFor(ctx context.Context, equipNo string) (*DataStatus, error) {
+	resp := &DataStatus{}
+	if err := conn.call(ctx, equipNo, cmdTypeStatus, []byte(`{"opt":1,"refreshData":1}`), resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// call sends a single MQTTRequest of the given cmdType with dataArea
+// as its payload, decoding the response's dataArea into out (which
+// may be nil for commands that don't return a useful payload). It
+// re-authenticates once and retries if the server reports the token
+// has expired, and otherwise implements the CRC32 framing, checksum
+// verification and State transitions shared by every command.
+func (conn *Conn) call(ctx context.Context, equipNo string, cmdType int, dataArea []byte, out any) error {
+	if err := conn.Ensure(ctx); err != nil {
+		return err
+	}
+	if err := conn.limiter.Load().wait(ctx); err != nil {
+		return err
+	}
+	err := conn.doCall(equipNo, cmdType, dataArea, out)
+	if err == errAuthExpired {
+		if err := conn.login(); err != nil {
+			return err
+		}
+		if err := conn.limiter.Load().wait(ctx); err != nil {
+			return err
+		}
+		err = conn.doCall(equipNo, cmdType, dataArea, out)
+	}
+	return err
+}
+
+// doCall performs a single sendMqtt request using the Conn's current
+// token, without any re-authentication or rate limiting.
+func (conn *Conn) doCall(equipNo string, cmdType int, dataArea []byte, out any) (err error) {
+	checksum := crc32.ChecksumIEEE(dataArea)
+
+	conn.mu.Lock()
+	token := conn.token
+	conn.mu.Unlock()
 
 	send := MQTTRequest{
 		Lang:      "EN_US",
-		CmdType:   203,
-		EquipNo:   conn.config.Device[0],
+		CmdType:   cmdType,
+		EquipNo:   equipNo,
 		Type:      0,
 		TimeStamp: time.Now().Unix(),
-		Snno:      1,
-		Len:       len(req),
+		Snno:      conn.nextSnno(),
+		Len:       len(dataArea),
 		CRC:       fmt.Sprintf("%08X", checksum),
 		DataArea:  ":data-area:",
 	}
@@ -214,14 +449,14 @@ func (conn *Conn) Status() (resp *DataStatus, err error) {
 		err = fmt.Errorf("preparation failed: %v", err2)
 		return
 	}
-	j = bytes.Replace(j, []byte(`":data-area:"`), []byte(req), 1)
+	j = bytes.Replace(j, []byte(`":data-area:"`), dataArea, 1)
 
 	fReq, err2 := http.NewRequest("POST", URLBase+"hes-gateway/terminal/sendMqtt", bytes.NewBuffer(j))
 	if err != nil {
 		err = fmt.Errorf("query preparation failed: %v", err2)
 		return
 	}
-	fReq.Header.Add("loginToken", conn.token)
+	fReq.Header.Add("loginToken", token)
 	fReq.Header.Add("Content-Type", "application/json")
 	fReq.Header.Add("user-agent", userAgent)
 
@@ -243,28 +478,36 @@ func (conn *Conn) Status() (resp *DataStatus, err error) {
 		return
 	}
 	switch mresp.Code {
-	case 102:
-		// Seems to require a simple retry.
+	case mqttCodeRetryLater:
+		conn.transition(Throttled)
 		err = ErrRetryLater
 		return
-	case 200:
+	case mqttCodeTokenExpired, mqttCodeTokenInvalid:
+		conn.transition(Expired)
+		err = errAuthExpired
+		return
+	case mqttCodeOK:
 		// OK
 	default:
+		conn.transition(Broken)
 		err = fmt.Errorf("unexpected mqtt code = %d", mresp.Code)
 		return
 	}
 
 	checksum = crc32.ChecksumIEEE([]byte(fmt.Sprintf("%q", mresp.Result.DataArea)))
-	if got, err2 := strconv.ParseUint(mresp.Result.CRC, 16, 32); err != nil {
-		err = fmt.Errorf("invalid CRC return got=%q which is not hex", mresp.Result.CRC, err2)
+	if got, err2 := strconv.ParseUint(mresp.Result.CRC, 16, 32); err2 != nil {
+		err = fmt.Errorf("invalid CRC return got=%q which is not hex: %v", mresp.Result.CRC, err2)
 		return
 	} else if uint32(got) != checksum {
 		err = fmt.Errorf("invalid CRC return got=%X, want=%X", got, checksum)
 		return
 	}
-	resp = &DataStatus{}
-	if err2 := json.Unmarshal([]byte(mresp.Result.DataArea), resp); err != nil {
-		err = fmt.Errorf("status report decode error: %v", err2)
+	conn.transition(Authenticated)
+	if out == nil || mresp.Result.DataArea == "" {
+		return
+	}
+	if err2 := json.Unmarshal([]byte(mresp.Result.DataArea), out); err2 != nil {
+		err = fmt.Errorf("response decode error: %v", err2)
 		return
 	}
 	return
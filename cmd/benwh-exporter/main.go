@@ -0,0 +1,88 @@
+// Program benwh-exporter runs as a long-lived daemon that polls a
+// FranklinWH device and exposes its status as Prometheus metrics on
+// an HTTP /metrics endpoint.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"zappem.net/pub/net/benwh"
+	"zappem.net/pub/net/benwh/exporter"
+)
+
+var (
+	config    = flag.String("config", "./benwh.config", "config file location")
+	listen    = flag.String("listen", ":9842", "address to serve /metrics on")
+	interval  = flag.Duration("interval", 30*time.Second, "polling interval")
+	credStore = flag.String("credstore", "file", "credential store to use: file, keyring or env")
+	email     = flag.String("email", "", "account email address (keyring credstore only)")
+	devices   = flag.String("devices", "", "comma separated device list (keyring credstore only)")
+)
+
+// newStore returns the benwh.CredentialStore selected by --credstore.
+func newStore() (benwh.CredentialStore, error) {
+	switch *credStore {
+	case "file":
+		return &benwh.FileCredentialStore{Path: *config}, nil
+	case "keyring":
+		return &benwh.KeyringCredentialStore{
+			Service: "benwh",
+			Email:   *email,
+			Device:  strings.Split(*devices, ","),
+		}, nil
+	case "env":
+		return &benwh.EnvCredentialStore{}, nil
+	default:
+		return nil, fmt.Errorf("unknown --credstore=%q (want file, keyring or env)", *credStore)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	store, err := newStore()
+	if err != nil {
+		log.Fatalf("credential store selection failed: %v", err)
+	}
+
+	conn, err := benwh.NewConnFromStore(store)
+	if err != nil {
+		log.Fatalf("unable to authenticate a connection: %v", err)
+	}
+
+	exp := exporter.New(conn, *interval)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exp.Handler())
+	srv := &http.Server{Addr: *listen, Handler: mux}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	go func() {
+		if err := exp.Run(ctx); err != nil && err != context.Canceled {
+			log.Printf("poll loop stopped: %v", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("serving /metrics on %s", *listen)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("metrics server failed: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package benwh
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+)
+
+// CredentialStore abstracts where a Config's credentials come from
+// and, where supported, go back to. This lets a CLI keep the MD5
+// password hash and device list out of a plaintext file by selecting
+// a different backend instead of changing how Conn uses them.
+type CredentialStore interface {
+	// Load returns the Config to authenticate with.
+	Load() (Config, error)
+	// Save persists conf for a future Load, if the backend supports
+	// it. Backends that can't store credentials (e.g. env vars)
+	// return an error.
+	Save(conf Config) error
+}
+
+// FileCredentialStore is the original JSON-file-on-disk backend.
+type FileCredentialStore struct {
+	// Path is the location of the config file, e.g. "./benwh.config".
+	Path string
+	// Mode is the permission bits used when writing the file.
+	// Zero defaults to 0600.
+	Mode os.FileMode
+}
+
+// Load reads and decodes the JSON config file at s.Path.
+func (s *FileCredentialStore) Load() (conf Config, err error) {
+	d, err := os.ReadFile(s.Path)
+	if err != nil {
+		return Config{}, fmt.Errorf("unable to read %q: %v", s.Path, err)
+	}
+	if err := json.Unmarshal(d, &conf); err != nil {
+		return Config{}, fmt.Errorf("unable to decode %q: %v", s.Path, err)
+	}
+	return conf, nil
+}
+
+// Save writes conf to s.Path as JSON.
+func (s *FileCredentialStore) Save(conf Config) error {
+	d, err := json.Marshal(conf)
+	if err != nil {
+		return fmt.Errorf("unable to marshal %q: %v", s.Path, err)
+	}
+	mode := s.Mode
+	if mode == 0 {
+		mode = 0600
+	}
+	if err := os.WriteFile(s.Path, d, mode); err != nil {
+		return fmt.Errorf("failed to write %q: %v", s.Path, err)
+	}
+	return nil
+}
+
+// KeyringCredentialStore stores the password in the OS keyring
+// (Keychain / Secret Service / Credential Manager, via
+// github.com/zalando/go-keyring) and keeps the email and device list
+// alongside it, since the keyring only holds a single secret per
+// service/user pair.
+type KeyringCredentialStore struct {
+	// Service names this application to the keyring, e.g. "benwh".
+	Service string
+	Email   string
+	Device  []string
+}
+
+// Load fetches the password from the keyring for s.Email.
+func (s *KeyringCredentialStore) Load() (Config, error) {
+	pass, err := keyring.Get(s.Service, s.Email)
+	if err != nil {
+		return Config{}, fmt.Errorf("keyring lookup for %q failed: %v", s.Email, err)
+	}
+	return Config{Email: s.Email, Device: s.Device, Password: pass}, nil
+}
+
+// Save stores conf.Password in the keyring under conf.Email, and
+// updates s.Email and s.Device so a Load on this same
+// KeyringCredentialStore value immediately reflects it. The keyring
+// only holds the password itself: a later process that constructs a
+// fresh KeyringCredentialStore (e.g. from CLI flags) must still supply
+// Email and Device itself; those are not persisted anywhere by Save.
+func (s *KeyringCredentialStore) Save(conf Config) error {
+	if err := keyring.Set(s.Service, conf.Email, conf.Password); err != nil {
+		return fmt.Errorf("keyring store for %q failed: %v", conf.Email, err)
+	}
+	s.Email = conf.Email
+	s.Device = conf.Device
+	return nil
+}
+
+// EnvCredentialStore reads credentials from environment variables, so
+// nothing touches disk or a keyring at all. It is read-only: Save
+// always fails.
+type EnvCredentialStore struct {
+	// EmailVar, DeviceVar and PasswordVar name the environment
+	// variables to read from. A zero value defaults to
+	// BENWH_EMAIL, BENWH_DEVICE and BENWH_PASSWORD respectively.
+	// DeviceVar holds a comma separated device list.
+	EmailVar, DeviceVar, PasswordVar string
+}
+
+// Load reads Config fields from the configured environment variables.
+func (s *EnvCredentialStore) Load() (Config, error) {
+	emailVar, deviceVar, passVar := s.EmailVar, s.DeviceVar, s.PasswordVar
+	if emailVar == "" {
+		emailVar = "BENWH_EMAIL"
+	}
+	if deviceVar == "" {
+		deviceVar = "BENWH_DEVICE"
+	}
+	if passVar == "" {
+		passVar = "BENWH_PASSWORD"
+	}
+	email := os.Getenv(emailVar)
+	pass := os.Getenv(passVar)
+	if email == "" || pass == "" {
+		return Config{}, fmt.Errorf("%s and %s must both be set", emailVar, passVar)
+	}
+	return Config{
+		Email:    email,
+		Device:   strings.Split(os.Getenv(deviceVar), ","),
+		Password: pass,
+	}, nil
+}
+
+// Save always fails: there is nowhere to persist credentials to when
+// they are sourced from the environment.
+func (s *EnvCredentialStore) Save(conf Config) error {
+	return fmt.Errorf("env credential store is read-only")
+}
+
+// NewConnFromStore loads a Config from store and authenticates a Conn
+// with it. Unlike NewConn, the Conn keeps a reference to store and
+// reloads its Config from it before every subsequent login, so a
+// password rotated in the store (e.g. in the OS keyring) is picked up
+// the next time the Conn needs to re-authenticate instead of being
+// silently stuck with the value it started with.
+func NewConnFromStore(store CredentialStore) (*Conn, error) {
+	conf, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("credential store load failed: %v", err)
+	}
+	return newConn(conf, store)
+}
@@ -3,12 +3,13 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"strings"
 	"syscall"
@@ -16,18 +17,71 @@ import (
 
 	"golang.org/x/term"
 	"zappem.net/pub/net/benwh"
+	"zappem.net/pub/net/benwh/recorder"
 )
 
 var (
-	email    = flag.String("email", "", "account email address")
-	devices  = flag.String("devices", "", "comma separated device list")
-	config   = flag.String("config", "./benwh.config", "config file location")
-	newLogin = flag.Bool("newlogin", false, "create new login config file")
-	debug    = flag.Bool("debug", false, "show all status data")
-	poll     = flag.Int("poll", 1, "number of samples to take before exit")
-	delay    = flag.Duration("delay", 0, "time to wait between service calls")
+	email      = flag.String("email", "", "account email address")
+	devices    = flag.String("devices", "", "comma separated device list")
+	config     = flag.String("config", "./benwh.config", "config file location")
+	newLogin   = flag.Bool("newlogin", false, "create new login config file")
+	debug      = flag.Bool("debug", false, "show all status data")
+	poll       = flag.Int("poll", 1, "number of samples to take before exit")
+	delay      = flag.Duration("delay", 0, "time to wait between service calls")
+	credStore  = flag.String("credstore", "file", "credential store to use: file, keyring or env")
+	record     = flag.String("record", "", "record samples to a sink, e.g. sqlite:///path/to/db, csv:///path/to.csv or influx:///path/to.line")
+	setMode    = flag.String("set-mode", "", "set the operating mode (self-consumption, time-of-use or backup) and exit")
+	setReserve = flag.Float64("set-reserve", -1, "set the battery reserve state of charge (%) and exit")
 )
 
+// newSink parses --record and opens the Sink it names, returning a nil
+// Sink when --record is unset.
+func newSink(spec string) (recorder.Sink, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --record=%q: %v", spec, err)
+	}
+	path := u.Opaque
+	if path == "" {
+		path = u.Path
+	}
+	switch u.Scheme {
+	case "sqlite":
+		return recorder.NewSQLiteSink(path)
+	case "csv":
+		return recorder.NewCSVSink(path)
+	case "influx":
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return recorder.NewInfluxLineSink(f, ""), nil
+	default:
+		return nil, fmt.Errorf("unknown --record scheme %q (want sqlite, csv or influx)", u.Scheme)
+	}
+}
+
+// newStore returns the benwh.CredentialStore selected by --credstore.
+func newStore() (benwh.CredentialStore, error) {
+	switch *credStore {
+	case "file":
+		return &benwh.FileCredentialStore{Path: *config}, nil
+	case "keyring":
+		return &benwh.KeyringCredentialStore{
+			Service: "benwh",
+			Email:   *email,
+			Device:  strings.Split(*devices, ","),
+		}, nil
+	case "env":
+		return &benwh.EnvCredentialStore{}, nil
+	default:
+		return nil, fmt.Errorf("unknown --credstore=%q (want file, keyring or env)", *credStore)
+	}
+}
+
 // createConfig reads information for the device config.
 func createConfig() (conf benwh.Config, err error) {
 	conf.Email = *email
@@ -66,21 +120,21 @@ func createConfig() (conf benwh.Config, err error) {
 func main() {
 	flag.Parse()
 
-	var conf benwh.Config
-	var err error
+	store, err := newStore()
+	if err != nil {
+		log.Fatalf("credential store selection failed: %v", err)
+	}
 
+	var conf benwh.Config
 	if *newLogin {
 		conf, err = createConfig()
 		if err != nil {
 			log.Fatalf("config creation failed: %v", err)
 		}
 	} else {
-		d, err := os.ReadFile(*config)
+		conf, err = store.Load()
 		if err != nil {
-			log.Fatalf("unable to read --config=%q: %v", *config, err)
-		}
-		if err := json.Unmarshal(d, &conf); err != nil {
-			log.Fatalf("unable to decode --config=%q: %v", *config, err)
+			log.Fatalf("unable to load credentials from --credstore=%q: %v", *credStore, err)
 		}
 	}
 
@@ -90,37 +144,94 @@ func main() {
 	}
 
 	if *newLogin {
-		d, err := json.Marshal(conf)
+		if err := store.Save(conf); err != nil {
+			log.Fatalf("unable to save credentials to --credstore=%q: %v", *credStore, err)
+		}
+	}
+
+	if *setMode != "" {
+		mode, err := benwh.ParseMode(*setMode)
 		if err != nil {
-			log.Fatalf("unable to marshal --config=%q: %v", *config, err)
+			log.Fatalf("invalid --set-mode: %v", err)
+		}
+		if err := conn.SetMode(context.Background(), conf.Device[0], mode); err != nil {
+			log.Fatalf("failed to set mode: %v", err)
 		}
-		if err := os.WriteFile(*config, d, 0600); err != nil {
-			log.Fatalf("failed to write --config=%q: %v", *config, err)
+		log.Printf("mode set to %v", mode)
+		return
+	}
+	if *setReserve >= 0 {
+		if err := conn.SetReserveSoc(context.Background(), conf.Device[0], *setReserve); err != nil {
+			log.Fatalf("failed to set reserve: %v", err)
 		}
+		log.Printf("reserve soc set to %.1f%%", *setReserve)
+		return
+	}
+
+	sink, err := newSink(*record)
+	if err != nil {
+		log.Fatalf("unable to open --record=%q: %v", *record, err)
+	}
+	var rec *recorder.Recorder
+	if sink != nil {
+		rec = recorder.New(sink)
+		defer rec.Close()
 	}
 
 	samples := 0
 	backoff := 5 * time.Second
 	for first := true; ; first = false {
-		resp, err := conn.Status()
-		switch err {
-		case nil:
-			backoff = 5 * time.Second
-		case benwh.ErrRetryLater:
-			backoff = backoff * 2
-			log.Printf("no data received (waiting %v): %v", backoff, err)
-			time.Sleep(backoff)
-			continue
-		default:
-			log.Fatalf("failed to obtain status: %v", err)
-		}
-		if *debug {
-			log.Printf("resp %#v", resp)
+		if len(conf.Device) > 1 {
+			all := conn.StatusAll(context.Background())
+			if *debug {
+				log.Printf("resp %#v", all)
+			} else {
+				if first {
+					log.Print("Device               (kW) Utility    Solar     Gen  A-Gate   House  %Charge")
+				}
+				for _, equipNo := range conf.Device {
+					resp, ok := all[equipNo]
+					if !ok {
+						log.Printf("%-18s       (no data)", equipNo)
+						continue
+					}
+					log.Printf("%-18s      %6.3f   %6.3f  %6.3f  %6.3f  %6.3f   %6.3f", equipNo, resp.PUti, resp.PSun, resp.PGen, resp.PFhp, resp.PLoad, resp.Soc)
+				}
+			}
+			if rec != nil {
+				now := time.Now()
+				for _, resp := range all {
+					if err := rec.Record(now, resp); err != nil {
+						log.Printf("recording failed: %v", err)
+					}
+				}
+			}
 		} else {
-			if first {
-				log.Print("(kW) Utility    Solar     Gen  A-Gate   House  %Charge")
+			resp, err := conn.Status()
+			switch err {
+			case nil:
+				backoff = 5 * time.Second
+			case benwh.ErrRetryLater:
+				backoff = backoff * 2
+				log.Printf("no data received (waiting %v): %v", backoff, err)
+				time.Sleep(backoff)
+				continue
+			default:
+				log.Fatalf("failed to obtain status: %v", err)
+			}
+			if rec != nil {
+				if err := rec.Record(time.Now(), resp); err != nil {
+					log.Printf("recording failed: %v", err)
+				}
+			}
+			if *debug {
+				log.Printf("resp %#v", resp)
+			} else {
+				if first {
+					log.Print("(kW) Utility    Solar     Gen  A-Gate   House  %Charge")
+				}
+				log.Printf("      %6.3f   %6.3f  %6.3f  %6.3f  %6.3f   %6.3f", resp.PUti, resp.PSun, resp.PGen, resp.PFhp, resp.PLoad, resp.Soc)
 			}
-			log.Printf("      %6.3f   %6.3f  %6.3f  %6.3f  %6.3f   %6.3f", resp.PUti, resp.PSun, resp.PGen, resp.PFhp, resp.PLoad, resp.Soc)
 		}
 		if *delay == 0 {
 			break
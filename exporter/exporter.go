@@ -0,0 +1,211 @@
+// Package exporter polls a FranklinWH device via a benwh.Conn and
+// republishes the resulting benwh.DataStatus as Prometheus metrics on
+// an HTTP /metrics endpoint.
+package exporter
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"zappem.net/pub/net/benwh"
+)
+
+// namespace is the common Prometheus metric name prefix used by every
+// metric this package exports.
+const namespace = "benwh"
+
+// Exporter polls a *benwh.Conn on a fixed interval and keeps a set of
+// Prometheus metrics in sync with the most recent benwh.DataStatus.
+type Exporter struct {
+	conn     *benwh.Conn
+	interval time.Duration
+	registry *prometheus.Registry
+
+	up              prometheus.Gauge
+	lastScrapeError prometheus.Counter
+
+	pUti  prometheus.Gauge
+	pSun  prometheus.Gauge
+	pGen  prometheus.Gauge
+	pFhp  prometheus.Gauge
+	pLoad prometheus.Gauge
+
+	// The kwh_* fields of DataStatus are the device's own absolute
+	// cumulative meter readings, not per-scrape deltas, so they are
+	// exported as Gauges set to that reading rather than Counters
+	// accumulated on top of it.
+	kwhUtiIn  prometheus.Gauge
+	kwhUtiOut prometheus.Gauge
+	kwhSun    prometheus.Gauge
+	kwhGen    prometheus.Gauge
+	kwhFhpDi  prometheus.Gauge
+	kwhFhpChg prometheus.Gauge
+	kwhLoad   prometheus.Gauge
+
+	soc        prometheus.Gauge
+	tAmb       prometheus.Gauge
+	signal     prometheus.Gauge
+	wifiSignal prometheus.Gauge
+	sinHTemp   prometheus.Gauge
+	sinLTemp   prometheus.Gauge
+
+	fhpSoc   *prometheus.GaugeVec
+	fhpPower *prometheus.GaugeVec
+}
+
+// New creates an Exporter that will poll conn every interval when Run
+// is called. Metrics are registered against a private registry so
+// multiple Exporters can coexist in the same process.
+func New(conn *benwh.Conn, interval time.Duration) *Exporter {
+	reg := prometheus.NewRegistry()
+	f := promauto.With(reg)
+
+	e := &Exporter{
+		conn:     conn,
+		interval: interval,
+		registry: reg,
+
+		up: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "up",
+			Help:      "1 if the last scrape of the device succeeded, 0 otherwise.",
+		}),
+		lastScrapeError: f.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "last_scrape_error",
+			Help:      "Number of scrapes that failed to obtain a status report.",
+		}),
+
+		pUti: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "p_uti_watts", Help: "Power flow from the utility (W)."}),
+		pSun: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "p_sun_watts", Help: "Power flow from solar (W)."}),
+		pGen: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "p_gen_watts", Help: "Power flow from the generator (W)."}),
+		pFhp: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "p_fhp_watts", Help: "Power flow through the aGate battery (W)."}),
+		pLoad: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "p_load_watts", Help: "Power flow to the house load (W)."}),
+
+		kwhUtiIn: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "kwh_uti_in", Help: "Cumulative energy imported from the utility, as reported by the device (kWh)."}),
+		kwhUtiOut: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "kwh_uti_out", Help: "Cumulative energy exported to the utility, as reported by the device (kWh)."}),
+		kwhSun: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "kwh_sun", Help: "Cumulative solar generation, as reported by the device (kWh)."}),
+		kwhGen: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "kwh_gen", Help: "Cumulative generator generation, as reported by the device (kWh)."}),
+		kwhFhpDi: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "kwh_fhp_discharge", Help: "Cumulative aGate battery discharge, as reported by the device (kWh)."}),
+		kwhFhpChg: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "kwh_fhp_charge", Help: "Cumulative aGate battery charge, as reported by the device (kWh)."}),
+		kwhLoad: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "kwh_load", Help: "Cumulative house load energy, as reported by the device (kWh)."}),
+
+		soc: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "soc_ratio", Help: "Aggregate battery state of charge (0-1)."}),
+		tAmb: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "ambient_temperature_celsius", Help: "Ambient temperature (C)."}),
+		signal: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "signal_strength", Help: "Cellular signal strength."}),
+		wifiSignal: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "wifi_signal_strength", Help: "WiFi signal strength."}),
+		sinHTemp: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "sink_high_temperature_celsius", Help: "Heatsink high temperature (C)."}),
+		sinLTemp: f.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "sink_low_temperature_celsius", Help: "Heatsink low temperature (C)."}),
+
+		fhpSoc: f.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "fhp_soc_ratio", Help: "Per-battery state of charge (0-1)."}, []string{"fhpSn"}),
+		fhpPower: f.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "fhp_power_watts", Help: "Per-battery power flow (W)."}, []string{"fhpSn"}),
+	}
+	return e
+}
+
+// Handler returns the HTTP handler to serve at the /metrics endpoint.
+func (e *Exporter) Handler() http.Handler {
+	return promhttp.HandlerFor(e.registry, promhttp.HandlerOpts{})
+}
+
+// scrape polls the device once and updates the metrics to match.
+func (e *Exporter) scrape() error {
+	resp, err := e.conn.Status()
+	if err != nil {
+		e.lastScrapeError.Inc()
+		e.up.Set(0)
+		return err
+	}
+
+	// DataStatus reports power flows in kW.
+	e.pUti.Set(resp.PUti * 1000)
+	e.pSun.Set(resp.PSun * 1000)
+	e.pGen.Set(resp.PGen * 1000)
+	e.pFhp.Set(resp.PFhp * 1000)
+	e.pLoad.Set(resp.PLoad * 1000)
+
+	e.kwhUtiIn.Set(resp.KwhUtiIn)
+	e.kwhUtiOut.Set(resp.KwhUtiOut)
+	e.kwhSun.Set(resp.KwhSun)
+	e.kwhGen.Set(resp.KwhGen)
+	e.kwhFhpDi.Set(resp.KwhFhpDi)
+	e.kwhFhpChg.Set(resp.KwhFhpChg)
+	e.kwhLoad.Set(resp.KwhLoad)
+
+	// DataStatus reports Soc as a percentage.
+	e.soc.Set(resp.Soc / 100)
+	e.tAmb.Set(resp.TAmb)
+	e.signal.Set(float64(resp.Signal))
+	e.wifiSignal.Set(float64(resp.WifiSignal))
+	e.sinHTemp.Set(float64(resp.SinHTemp))
+	e.sinLTemp.Set(float64(resp.SinLTemp))
+
+	for i, sn := range resp.FhpSn {
+		if i < len(resp.FhpSoc) {
+			e.fhpSoc.WithLabelValues(sn).Set(resp.FhpSoc[i])
+		}
+		if i < len(resp.FhpPower) {
+			e.fhpPower.WithLabelValues(sn).Set(resp.FhpPower[i])
+		}
+	}
+
+	e.up.Set(1)
+	return nil
+}
+
+// Run polls the device at the configured interval until ctx is
+// canceled, applying exponential backoff whenever the server reports
+// benwh.ErrRetryLater. Scrape errors other than ErrRetryLater are
+// logged and reflected in the benwh_last_scrape_error counter, but do
+// not stop the loop.
+func (e *Exporter) Run(ctx context.Context) error {
+	backoff := 5 * time.Second
+	for {
+		err := e.scrape()
+		wait := e.interval
+		switch {
+		case err == nil:
+			backoff = 5 * time.Second
+		case err == benwh.ErrRetryLater:
+			wait = backoff
+			backoff *= 2
+			if backoff > 5*time.Minute {
+				backoff = 5 * time.Minute
+			}
+			log.Printf("exporter: retry later (next attempt in %v)", wait)
+		default:
+			log.Printf("exporter: scrape failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package benwh
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxConcurrentPolls bounds how many sendMqtt requests StatusAll will
+// have in flight at once, independent of how many devices are
+// configured.
+const maxConcurrentPolls = 4
+
+// rateLimiter is a simple token bucket shared by every request a Conn
+// makes. The FranklinWH server throttles aggressively (mqttCodeRetryLater)
+// when hit too fast, which matters once multiple devices are being
+// polled concurrently on the same account.
+type rateLimiter struct {
+	mu       sync.Mutex
+	tokens   float64
+	burst    float64
+	perSec   float64
+	lastFill time.Time
+}
+
+// newRateLimiter creates a token bucket that admits perSec requests a
+// second on average, allowing bursts of up to burst requests.
+func newRateLimiter(perSec float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		tokens:   float64(burst),
+		burst:    float64(burst),
+		perSec:   perSec,
+		lastFill: time.Now(),
+	}
+}
+
+// SetRateLimit reconfigures the Conn's request rate. It is safe to
+// call concurrently with requests in flight; in-flight waits finish
+// against whichever limiter was current when they started.
+func (conn *Conn) SetRateLimit(perSec float64, burst int) {
+	conn.limiter.Store(newRateLimiter(perSec, burst))
+}
+
+// wait blocks until a token is available or ctx is done.
+func (r *rateLimiter) wait(ctx context.Context) error {
+	for {
+		r.mu.Lock()
+		now := time.Now()
+		r.tokens += now.Sub(r.lastFill).Seconds() * r.perSec
+		if r.tokens > r.burst {
+			r.tokens = r.burst
+		}
+		r.lastFill = now
+		if r.tokens >= 1 {
+			r.tokens--
+			r.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - r.tokens) / r.perSec * float64(time.Second))
+		r.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// StatusAll concurrently polls every device in conn.config.Device and
+// returns the status obtained for each, keyed by equipNo. Devices
+// that never succeed before ctx is done are simply absent from the
+// result; callers that need to know why should poll StatusFor
+// directly instead. Polling is spread across a bounded worker pool so
+// a long device list doesn't open unbounded concurrent requests, and
+// each device backs off independently on ErrRetryLater.
+func (conn *Conn) StatusAll(ctx context.Context) map[string]*DataStatus {
+	results := make(map[string]*DataStatus, len(conn.config.Device))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentPolls)
+
+	for _, equipNo := range conn.config.Device {
+		equipNo := equipNo
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+
+			resp, err := conn.pollWithBackoff(ctx, equipNo)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			results[equipNo] = resp
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// pollWithBackoff calls StatusFor for equipNo, retrying with
+// exponential backoff whenever the server reports ErrRetryLater, until
+// it succeeds, ctx is done, or some other error occurs.
+func (conn *Conn) pollWithBackoff(ctx context.Context, equipNo string) (*DataStatus, error) {
+	backoff := 5 * time.Second
+	for {
+		resp, err := conn.StatusFor(ctx, equipNo)
+		switch err {
+		case nil:
+			return resp, nil
+		case ErrRetryLater:
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > time.Minute {
+				backoff = time.Minute
+			}
+		default:
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package recorder
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"time"
+)
+
+var csvHeader = []string{
+	"time", "resolution",
+	"p_uti", "p_sun", "p_gen", "p_fhp", "p_load", "soc",
+	"kwh_uti_in", "kwh_uti_out", "kwh_sun", "kwh_gen", "kwh_fhp_di", "kwh_fhp_chg", "kwh_load",
+}
+
+// CSVSink appends Records to a CSV file, writing a header line only
+// when the file is new or empty.
+type CSVSink struct {
+	f           *os.File
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+// NewCSVSink opens (creating if necessary) the CSV file at path for
+// appending.
+func NewCSVSink(path string) (*CSVSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &CSVSink{
+		f:           f,
+		w:           csv.NewWriter(f),
+		wroteHeader: info.Size() > 0,
+	}, nil
+}
+
+// Write appends rec as a CSV row.
+func (s *CSVSink) Write(rec Record) error {
+	if !s.wroteHeader {
+		if err := s.w.Write(csvHeader); err != nil {
+			return err
+		}
+		s.wroteHeader = true
+	}
+	row := []string{
+		rec.Time.UTC().Format(time.RFC3339),
+		string(rec.Resolution),
+		strconv.FormatFloat(rec.PUti, 'f', -1, 64),
+		strconv.FormatFloat(rec.PSun, 'f', -1, 64),
+		strconv.FormatFloat(rec.PGen, 'f', -1, 64),
+		strconv.FormatFloat(rec.PFhp, 'f', -1, 64),
+		strconv.FormatFloat(rec.PLoad, 'f', -1, 64),
+		strconv.FormatFloat(rec.Soc, 'f', -1, 64),
+		strconv.FormatFloat(rec.KwhUtiIn, 'f', -1, 64),
+		strconv.FormatFloat(rec.KwhUtiOut, 'f', -1, 64),
+		strconv.FormatFloat(rec.KwhSun, 'f', -1, 64),
+		strconv.FormatFloat(rec.KwhGen, 'f', -1, 64),
+		strconv.FormatFloat(rec.KwhFhpDi, 'f', -1, 64),
+		strconv.FormatFloat(rec.KwhFhpChg, 'f', -1, 64),
+		strconv.FormatFloat(rec.KwhLoad, 'f', -1, 64),
+	}
+	if err := s.w.Write(row); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// Close flushes any buffered output and closes the underlying file.
+func (s *CSVSink) Close() error {
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
@@ -0,0 +1,44 @@
+package recorder
+
+import (
+	"fmt"
+	"io"
+)
+
+// InfluxLineSink writes Records as InfluxDB line protocol to w, one
+// line per Record. It does not talk to an InfluxDB server directly;
+// point w at a file under an InfluxDB/Telegraf line-protocol import
+// directory, or wrap an HTTP request body, as needed.
+type InfluxLineSink struct {
+	w           io.Writer
+	measurement string
+}
+
+// NewInfluxLineSink writes to w using measurement as the line
+// protocol measurement name. An empty measurement defaults to "benwh".
+func NewInfluxLineSink(w io.Writer, measurement string) *InfluxLineSink {
+	if measurement == "" {
+		measurement = "benwh"
+	}
+	return &InfluxLineSink{w: w, measurement: measurement}
+}
+
+// Write emits rec as a single line-protocol line.
+func (s *InfluxLineSink) Write(rec Record) error {
+	_, err := fmt.Fprintf(s.w,
+		"%s,resolution=%s p_uti=%g,p_sun=%g,p_gen=%g,p_fhp=%g,p_load=%g,soc=%g,"+
+			"kwh_uti_in=%g,kwh_uti_out=%g,kwh_sun=%g,kwh_gen=%g,kwh_fhp_di=%g,kwh_fhp_chg=%g,kwh_load=%g %d\n",
+		s.measurement, rec.Resolution,
+		rec.PUti, rec.PSun, rec.PGen, rec.PFhp, rec.PLoad, rec.Soc,
+		rec.KwhUtiIn, rec.KwhUtiOut, rec.KwhSun, rec.KwhGen, rec.KwhFhpDi, rec.KwhFhpChg, rec.KwhLoad,
+		rec.Time.UnixNano())
+	return err
+}
+
+// Close closes w if it implements io.Closer.
+func (s *InfluxLineSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
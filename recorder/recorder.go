@@ -0,0 +1,207 @@
+// Package recorder consumes *benwh.DataStatus samples and writes them
+// to a pluggable Sink, downsampling on the fly so long-running polling
+// sessions build a queryable local history without needing to retain
+// every raw sample forever.
+package recorder
+
+import (
+	"sync"
+	"time"
+
+	"zappem.net/pub/net/benwh"
+)
+
+// Resolution identifies how a Record was produced.
+type Resolution string
+
+const (
+	// Raw records are one per poll, as received.
+	Raw Resolution = "raw"
+	// Minute records are 1-minute averages.
+	Minute Resolution = "1m"
+	// Quarter records are 15-minute averages.
+	Quarter Resolution = "15m"
+)
+
+// Record is a single row written to a Sink: the mean of the power
+// fields and the most recent value of the cumulative kwh_* counters
+// over whatever window Resolution describes.
+type Record struct {
+	Time       time.Time
+	Resolution Resolution
+
+	PUti, PSun, PGen, PFhp, PLoad, Soc float64
+
+	KwhUtiIn, KwhUtiOut, KwhSun, KwhGen, KwhFhpDi, KwhFhpChg, KwhLoad float64
+}
+
+func recordFromStatus(t time.Time, d *benwh.DataStatus) Record {
+	return Record{
+		Time:      t,
+		PUti:      d.PUti,
+		PSun:      d.PSun,
+		PGen:      d.PGen,
+		PFhp:      d.PFhp,
+		PLoad:     d.PLoad,
+		Soc:       d.Soc,
+		KwhUtiIn:  d.KwhUtiIn,
+		KwhUtiOut: d.KwhUtiOut,
+		KwhSun:    d.KwhSun,
+		KwhGen:    d.KwhGen,
+		KwhFhpDi:  d.KwhFhpDi,
+		KwhFhpChg: d.KwhFhpChg,
+		KwhLoad:   d.KwhLoad,
+	}
+}
+
+// Sink is where Records end up. Implementations include CSVSink,
+// SQLiteSink and InfluxLineSink.
+type Sink interface {
+	Write(rec Record) error
+	Close() error
+}
+
+// Pruner is implemented by Sinks that can discard old Records of a
+// given Resolution, e.g. to enforce the raw/minute/quarter retention
+// windows that Recorder expects. Sinks that only append, such as
+// InfluxLineSink, need not implement it.
+type Pruner interface {
+	PruneBefore(res Resolution, before time.Time) error
+}
+
+// bucket accumulates the running sum of an in-progress average.
+type bucket struct {
+	start time.Time
+	n     int
+	sum   Record
+}
+
+func newBucket(start time.Time) *bucket {
+	return &bucket{start: start}
+}
+
+func (b *bucket) add(rec Record) {
+	b.n++
+	b.sum.PUti += rec.PUti
+	b.sum.PSun += rec.PSun
+	b.sum.PGen += rec.PGen
+	b.sum.PFhp += rec.PFhp
+	b.sum.PLoad += rec.PLoad
+	b.sum.Soc += rec.Soc
+	// Cumulative counters take the most recent value, not a sum.
+	b.sum.KwhUtiIn = rec.KwhUtiIn
+	b.sum.KwhUtiOut = rec.KwhUtiOut
+	b.sum.KwhSun = rec.KwhSun
+	b.sum.KwhGen = rec.KwhGen
+	b.sum.KwhFhpDi = rec.KwhFhpDi
+	b.sum.KwhFhpChg = rec.KwhFhpChg
+	b.sum.KwhLoad = rec.KwhLoad
+}
+
+func (b *bucket) mean(res Resolution) Record {
+	n := float64(b.n)
+	if n == 0 {
+		n = 1
+	}
+	out := b.sum
+	out.Time = b.start
+	out.Resolution = res
+	out.PUti /= n
+	out.PSun /= n
+	out.PGen /= n
+	out.PFhp /= n
+	out.PLoad /= n
+	out.Soc /= n
+	return out
+}
+
+// Recorder feeds every sample it is given to a Sink at raw
+// resolution, while also maintaining 1-minute and 15-minute rolling
+// averages that are flushed to the Sink at each bucket boundary. If
+// the Sink implements Pruner, Recorder asks it to discard raw samples
+// older than an hour and minute samples older than a day, leaving
+// 15-minute samples to accumulate indefinitely - the classic RRD-style
+// rollup.
+type Recorder struct {
+	mu   sync.Mutex
+	sink Sink
+
+	rawRetention    time.Duration
+	minuteRetention time.Duration
+
+	minuteBucket  *bucket
+	quarterBucket *bucket
+}
+
+// New creates a Recorder that writes to sink.
+func New(sink Sink) *Recorder {
+	return &Recorder{
+		sink:            sink,
+		rawRetention:    time.Hour,
+		minuteRetention: 24 * time.Hour,
+	}
+}
+
+// Record folds a status sample taken at time t into the current
+// minute and quarter-hour buckets, flushing each to the Sink as raw
+// and bucket boundaries are crossed.
+func (r *Recorder) Record(t time.Time, status *benwh.DataStatus) error {
+	rec := recordFromStatus(t, status)
+	rec.Resolution = Raw
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.sink.Write(rec); err != nil {
+		return err
+	}
+
+	if err := r.roll(&r.minuteBucket, time.Minute, Minute, t, rec); err != nil {
+		return err
+	}
+	if err := r.roll(&r.quarterBucket, 15*time.Minute, Quarter, t, rec); err != nil {
+		return err
+	}
+
+	if pruner, ok := r.sink.(Pruner); ok {
+		if err := pruner.PruneBefore(Raw, t.Add(-r.rawRetention)); err != nil {
+			return err
+		}
+		if err := pruner.PruneBefore(Minute, t.Add(-r.minuteRetention)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// roll advances *b to cover t, flushing the previous bucket's average
+// to the Sink when t has moved past it.
+func (r *Recorder) roll(b **bucket, width time.Duration, res Resolution, t time.Time, rec Record) error {
+	if *b == nil || t.Sub((*b).start) >= width {
+		if *b != nil {
+			if err := r.sink.Write((*b).mean(res)); err != nil {
+				return err
+			}
+		}
+		*b = newBucket(t.Truncate(width))
+	}
+	(*b).add(rec)
+	return nil
+}
+
+// Close flushes any in-progress buckets and closes the underlying Sink.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.minuteBucket != nil {
+		if err := r.sink.Write(r.minuteBucket.mean(Minute)); err != nil {
+			return err
+		}
+	}
+	if r.quarterBucket != nil {
+		if err := r.sink.Write(r.quarterBucket.mean(Quarter)); err != nil {
+			return err
+		}
+	}
+	return r.sink.Close()
+}
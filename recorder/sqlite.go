@@ -0,0 +1,74 @@
+package recorder
+
+import (
+	"database/sql"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS samples (
+	time        TEXT NOT NULL,
+	resolution  TEXT NOT NULL,
+	p_uti       REAL,
+	p_sun       REAL,
+	p_gen       REAL,
+	p_fhp       REAL,
+	p_load      REAL,
+	soc         REAL,
+	kwh_uti_in  REAL,
+	kwh_uti_out REAL,
+	kwh_sun     REAL,
+	kwh_gen     REAL,
+	kwh_fhp_di  REAL,
+	kwh_fhp_chg REAL,
+	kwh_load    REAL
+);
+CREATE INDEX IF NOT EXISTS samples_by_resolution ON samples (resolution, time);
+`
+
+// SQLiteSink writes Records into a "samples" table of a SQLite
+// database opened via modernc.org/sqlite, which needs no cgo.
+type SQLiteSink struct {
+	db *sql.DB
+}
+
+// NewSQLiteSink opens (creating if necessary) the SQLite database at
+// path and ensures its schema exists.
+func NewSQLiteSink(path string) (*SQLiteSink, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteSink{db: db}, nil
+}
+
+// Write inserts rec as a new row.
+func (s *SQLiteSink) Write(rec Record) error {
+	_, err := s.db.Exec(`
+INSERT INTO samples (
+	time, resolution, p_uti, p_sun, p_gen, p_fhp, p_load, soc,
+	kwh_uti_in, kwh_uti_out, kwh_sun, kwh_gen, kwh_fhp_di, kwh_fhp_chg, kwh_load
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rec.Time.UTC().Format(time.RFC3339), string(rec.Resolution),
+		rec.PUti, rec.PSun, rec.PGen, rec.PFhp, rec.PLoad, rec.Soc,
+		rec.KwhUtiIn, rec.KwhUtiOut, rec.KwhSun, rec.KwhGen, rec.KwhFhpDi, rec.KwhFhpChg, rec.KwhLoad)
+	return err
+}
+
+// PruneBefore deletes rows of the given Resolution older than before.
+func (s *SQLiteSink) PruneBefore(res Resolution, before time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM samples WHERE resolution = ? AND time < ?`,
+		string(res), before.UTC().Format(time.RFC3339))
+	return err
+}
+
+// Close closes the underlying database handle.
+func (s *SQLiteSink) Close() error {
+	return s.db.Close()
+}